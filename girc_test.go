@@ -0,0 +1,94 @@
+package girc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFailClosesStoneConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	connection := New(listener.Addr().String(), "nick")
+	connection.PingFreq = 50 * time.Millisecond
+	connection.Timeout = 200 * time.Millisecond
+	if err := connection.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer connection.Close()
+
+	var serverConn net.Conn
+	select {
+	case serverConn = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("server never accepted the connection")
+	}
+	defer serverConn.Close()
+
+	// The server never sends anything, so this cycle's read deadline
+	// (Timeout+PingFreq, 250ms here) expires before the pinger's first
+	// one-second tick, and readLoop hands the timeout to fail().
+	select {
+	case <-connection.Err:
+	case <-time.After(3 * time.Second):
+		t.Fatal("fail() was never triggered by the idle-server timeout")
+	}
+
+	if _, err := connection.conn.Write([]byte("x")); err == nil {
+		t.Fatal("expected the old socket to be closed once fail() ran, but a write to it succeeded")
+	}
+}
+
+func TestCloseWithoutLoopClosesFinishedAndListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	connection := New(listener.Addr().String(), "nick")
+	if err := connection.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	events := make(chan *Command, 1)
+	connection.AddListener(events)
+
+	if err := connection.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case <-connection.Finished:
+	case <-time.After(time.Second):
+		t.Fatal("Finished was never closed after Close() without Loop()")
+	}
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("expected listener channel to be closed, got a value instead")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener channel was never closed after Close() without Loop()")
+	}
+}
@@ -0,0 +1,263 @@
+package girc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// struct Command describes an IRC command. Any IRC command
+// consists of an optional set of IRCv3 message tags, an optional
+// source hostname, the type of the command (for example PING, or
+// PRIVMSG) followed by a number of arguments. Only the final
+// argument may have spaces in it. These are represented with Tags,
+// Source, Type, and Args respectively.
+type Command struct {
+	Tags   map[string]string
+	Source string
+	Type   string
+	Args   []string
+}
+
+// maxTagBytes and maxBodyBytes are the limits from the IRCv3
+// message-tags spec: the tags portion (including the leading '@' and
+// trailing space) may be up to 8192 bytes, and the rest of the line
+// (including the trailing CRLF) is limited to the traditional
+// RFC 1459 512 bytes.
+const (
+	maxTagBytes  = 8192
+	maxBodyBytes = 512
+)
+
+// rawToCommand parses a single line received from the server,
+// including any IRCv3 message tags, into a Command. It follows the
+// IRCv3 message-tags and RFC 1459 grammars: an optional
+// "@tag1=val1;tag2=val2 " segment, an optional ":source " prefix, the
+// command verb or 3-digit numeric, zero or more middle parameters
+// separated by (possibly repeated) spaces, and an optional final
+// trailing parameter introduced by " :" that may itself contain
+// spaces and colons.
+func rawToCommand(raw string) (*Command, error) {
+	raw = strings.TrimRight(raw, "\r\n")
+	if raw == "" {
+		return nil, errors.New("empty message")
+	}
+
+	var command Command
+
+	if strings.HasPrefix(raw, "@") {
+		space := strings.IndexByte(raw, ' ')
+		if space == -1 {
+			return nil, errors.New("malformed tags: missing terminating space")
+		}
+		if space+1 > maxTagBytes {
+			return nil, errors.New("message tags exceed 8192 bytes")
+		}
+		command.Tags = parseTags(raw[1:space])
+		raw = strings.TrimLeft(raw[space+1:], " ")
+	}
+
+	if len(raw)+2 > maxBodyBytes {
+		return nil, errors.New("message exceeds 512 byte limit")
+	}
+
+	if strings.HasPrefix(raw, ":") {
+		space := strings.IndexByte(raw, ' ')
+		if space == -1 {
+			return nil, errors.New("malformed message: missing command after source")
+		}
+		command.Source = raw[1:space]
+		raw = strings.TrimLeft(raw[space+1:], " ")
+	}
+
+	if raw == "" {
+		return nil, errors.New("malformed message: missing command")
+	}
+
+	if space := strings.IndexByte(raw, ' '); space == -1 {
+		command.Type = raw
+		raw = ""
+	} else {
+		command.Type = raw[:space]
+		raw = raw[space+1:]
+	}
+
+	command.Args = parseParams(raw)
+
+	return &command, nil
+}
+
+// parseParams splits the middle and trailing parameters of a
+// message. Runs of spaces between parameters are treated as a single
+// separator, per RFC 1459's SPACE ::= ' ' { ' ' }, and a parameter is
+// only ever treated as the trailing one if it begins with ':' right
+// at a parameter boundary (not merely somewhere inside a word).
+func parseParams(raw string) []string {
+	var args []string
+
+	for {
+		raw = strings.TrimLeft(raw, " ")
+		if raw == "" {
+			break
+		}
+		if raw[0] == ':' {
+			args = append(args, raw[1:])
+			break
+		}
+		space := strings.IndexByte(raw, ' ')
+		if space == -1 {
+			args = append(args, raw)
+			break
+		}
+		args = append(args, raw[:space])
+		raw = raw[space+1:]
+	}
+
+	return args
+}
+
+// parseTags turns the inside of an "@tag1=val1;tag2=val2" segment
+// (everything between the '@' and the following space) into a map,
+// unescaping values per the IRCv3 message-tags spec.
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		if eq := strings.IndexByte(tag, '='); eq != -1 {
+			tags[tag[:eq]] = unescapeTagValue(tag[eq+1:])
+		} else {
+			tags[tag] = ""
+		}
+	}
+
+	return tags
+}
+
+// unescapeTagValue reverses escapeTagValue: \: -> ;, \s -> space,
+// \\ -> \, \r -> CR, \n -> LF. Any other escaped character is passed
+// through as-is, and a trailing lone backslash is dropped, both per
+// spec.
+func unescapeTagValue(raw string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			out.WriteByte(raw[i])
+			continue
+		}
+		if i+1 >= len(raw) {
+			break
+		}
+		i++
+		switch raw[i] {
+		case ':':
+			out.WriteByte(';')
+		case 's':
+			out.WriteByte(' ')
+		case '\\':
+			out.WriteByte('\\')
+		case 'r':
+			out.WriteByte('\r')
+		case 'n':
+			out.WriteByte('\n')
+		default:
+			out.WriteByte(raw[i])
+		}
+	}
+
+	return out.String()
+}
+
+// escapeTagValue is the inverse of unescapeTagValue, used by Raw()
+// when serializing a Command's Tags back to wire format.
+func escapeTagValue(raw string) string {
+	var out strings.Builder
+
+	for _, r := range raw {
+		switch r {
+		case ';':
+			out.WriteString(`\:`)
+		case ' ':
+			out.WriteString(`\s`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\n':
+			out.WriteString(`\n`)
+		default:
+			out.WriteRune(r)
+		}
+	}
+
+	return out.String()
+}
+
+// containsCRLF reports whether s contains an embedded carriage
+// return or line feed, which would otherwise let a caller smuggle an
+// extra line into the connection.
+func containsCRLF(s string) bool {
+	return strings.ContainsAny(s, "\r\n")
+}
+
+// Raw turns a given Command into its wire form. See RFC 1459
+// section 2.3 <http://tools.ietf.org/html/rfc1459.html#section-2.3>
+// and the IRCv3 message-tags spec for details on how this is
+// accomplished. It returns an error if any non-final argument
+// contains a space, or if Source, Type or any Arg contains an
+// embedded CR or LF.
+func (command *Command) Raw() (string, error) {
+	out := []string{}
+
+	if len(command.Tags) > 0 {
+		tagParts := make([]string, 0, len(command.Tags))
+		for key, value := range command.Tags {
+			if value == "" {
+				tagParts = append(tagParts, key)
+			} else {
+				tagParts = append(tagParts, key+"="+escapeTagValue(value))
+			}
+		}
+		sort.Strings(tagParts)
+		out = append(out, "@"+strings.Join(tagParts, ";"))
+	}
+
+	if containsCRLF(command.Source) {
+		return "", errors.New("source contains embedded CR/LF")
+	}
+	if command.Source != "" {
+		out = append(out, ":"+command.Source)
+	}
+
+	if containsCRLF(command.Type) {
+		return "", errors.New("command type contains embedded CR/LF")
+	}
+	out = append(out, command.Type)
+
+	if len(command.Args) > 0 {
+		for _, arg := range command.Args[0 : len(command.Args)-1] {
+			if strings.Contains(arg, " ") {
+				return "", errors.New("nonfinal argument contains space")
+			}
+			if containsCRLF(arg) {
+				return "", errors.New("argument contains embedded CR/LF")
+			}
+			out = append(out, arg)
+		}
+
+		last := command.Args[len(command.Args)-1]
+		if containsCRLF(last) {
+			return "", errors.New("argument contains embedded CR/LF")
+		}
+		if last == "" || strings.HasPrefix(last, ":") || strings.Contains(last, " ") {
+			out = append(out, ":"+last)
+		} else {
+			out = append(out, last)
+		}
+	}
+
+	return strings.Join(out, " ") + "\r\n", nil
+}
@@ -0,0 +1,222 @@
+package girc
+
+import (
+	"reflect"
+	"testing"
+)
+
+// splitCases are hand-authored, in the style of (but not sourced
+// from) the "msg-split" vectors in the irc-parser-tests project
+// (https://github.com/ircdocs/parser-tests): a raw line paired with
+// the Source/Type/Args/Tags it should parse into. This is not the
+// vendored YAML suite itself, so coverage is narrower - notably
+// missing the upstream surrogate/UTF-8 edge cases and full msg-join
+// vectors.
+var splitCases = []struct {
+	name string
+	raw  string
+	want Command
+}{
+	{
+		name: "simple verb, no args",
+		raw:  "QUIT\r\n",
+		want: Command{Type: "QUIT"},
+	},
+	{
+		name: "source and trailing only",
+		raw:  ":dan-!d@localhost PRIVMSG #chan :Hey!",
+		want: Command{Source: "dan-!d@localhost", Type: "PRIVMSG", Args: []string{"#chan", "Hey!"}},
+	},
+	{
+		name: "numeric reply",
+		raw:  ":irc.example.com 001 dan :Welcome to the server",
+		want: Command{Source: "irc.example.com", Type: "001", Args: []string{"dan", "Welcome to the server"}},
+	},
+	{
+		name: "colon inside the trailing param is kept verbatim",
+		raw:  "PRIVMSG #chan :isn't it :funny?",
+		want: Command{Type: "PRIVMSG", Args: []string{"#chan", "isn't it :funny?"}},
+	},
+	{
+		name: "no trailing parameter at all",
+		raw:  "MODE #chan +o dan",
+		want: Command{Type: "MODE", Args: []string{"#chan", "+o", "dan"}},
+	},
+	{
+		name: "repeated spaces between params collapse to one separator",
+		raw:  "MODE   #chan   +o  dan",
+		want: Command{Type: "MODE", Args: []string{"#chan", "+o", "dan"}},
+	},
+	{
+		name: "empty trailing parameter",
+		raw:  "PRIVMSG #chan :",
+		want: Command{Type: "PRIVMSG", Args: []string{"#chan", ""}},
+	},
+	{
+		name: "message tags",
+		raw:  "@id=123;time=2019-01-01T00:00:00.000Z :dan!d@localhost PRIVMSG #chan :hi",
+		want: Command{
+			Tags:   map[string]string{"id": "123", "time": "2019-01-01T00:00:00.000Z"},
+			Source: "dan!d@localhost",
+			Type:   "PRIVMSG",
+			Args:   []string{"#chan", "hi"},
+		},
+	},
+	{
+		name: "tag escapes",
+		raw:  `@a=b\:c\sd\\e\nf\rg PRIVMSG #chan :hi`,
+		want: Command{
+			Tags: map[string]string{"a": "b;c d\\e\nf\rg"},
+			Type: "PRIVMSG",
+			Args: []string{"#chan", "hi"},
+		},
+	},
+	{
+		name: "valueless tag",
+		raw:  "@verified PRIVMSG #chan :hi",
+		want: Command{
+			Tags: map[string]string{"verified": ""},
+			Type: "PRIVMSG",
+			Args: []string{"#chan", "hi"},
+		},
+	},
+}
+
+func TestRawToCommand(t *testing.T) {
+	for _, c := range splitCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := rawToCommand(c.raw)
+			if err != nil {
+				t.Fatalf("rawToCommand(%q) returned error: %v", c.raw, err)
+			}
+
+			if got.Source != c.want.Source {
+				t.Errorf("Source = %q, want %q", got.Source, c.want.Source)
+			}
+			if got.Type != c.want.Type {
+				t.Errorf("Type = %q, want %q", got.Type, c.want.Type)
+			}
+			if !reflect.DeepEqual(got.Args, c.want.Args) {
+				t.Errorf("Args = %#v, want %#v", got.Args, c.want.Args)
+			}
+			if len(c.want.Tags) == 0 {
+				if len(got.Tags) != 0 {
+					t.Errorf("Tags = %#v, want none", got.Tags)
+				}
+			} else if !reflect.DeepEqual(got.Tags, c.want.Tags) {
+				t.Errorf("Tags = %#v, want %#v", got.Tags, c.want.Tags)
+			}
+		})
+	}
+}
+
+func TestRawToCommandErrors(t *testing.T) {
+	cases := []string{
+		"",
+		"\r\n",
+		":onlysource",
+		"@unterminatedtags",
+	}
+
+	for _, raw := range cases {
+		if _, err := rawToCommand(raw); err == nil {
+			t.Errorf("rawToCommand(%q) expected an error, got none", raw)
+		}
+	}
+}
+
+func TestCommandRaw(t *testing.T) {
+	cases := []struct {
+		name    string
+		command Command
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "simple",
+			command: Command{Type: "PING", Args: []string{"token"}},
+			want:    "PING token\r\n",
+		},
+		{
+			name:    "trailing needed for spaces",
+			command: Command{Type: "PRIVMSG", Args: []string{"#chan", "hello there"}},
+			want:    "PRIVMSG #chan :hello there\r\n",
+		},
+		{
+			name:    "trailing needed for leading colon",
+			command: Command{Type: "PRIVMSG", Args: []string{"#chan", ":)"}},
+			want:    "PRIVMSG #chan ::)\r\n",
+		},
+		{
+			name:    "trailing needed for empty last arg",
+			command: Command{Type: "PRIVMSG", Args: []string{"#chan", ""}},
+			want:    "PRIVMSG #chan :\r\n",
+		},
+		{
+			name:    "source is serialized",
+			command: Command{Source: "dan", Type: "NICK", Args: []string{"dan2"}},
+			want:    ":dan NICK dan2\r\n",
+		},
+		{
+			name:    "tags are serialized and escaped, sorted for determinism",
+			command: Command{Tags: map[string]string{"b": "2", "a": "has space"}, Type: "PING", Args: []string{"x"}},
+			want:    "@a=has\\sspace;b=2 PING x\r\n",
+		},
+		{
+			name:    "nonfinal argument with space is an error",
+			command: Command{Type: "PRIVMSG", Args: []string{"has space", "trailing"}},
+			wantErr: true,
+		},
+		{
+			name:    "embedded CRLF is an error",
+			command: Command{Type: "PRIVMSG", Args: []string{"#chan\r\nQUIT"}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.command.Raw()
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Raw() expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Raw() returned error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("Raw() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+// TestRoundTrip parses a line and re-serializes it, checking that the
+// command survives the round trip (modulo tag ordering, which Raw()
+// canonicalizes by sorting).
+func TestRoundTrip(t *testing.T) {
+	for _, c := range splitCases {
+		t.Run(c.name, func(t *testing.T) {
+			parsed, err := rawToCommand(c.raw)
+			if err != nil {
+				t.Fatalf("rawToCommand(%q) returned error: %v", c.raw, err)
+			}
+
+			raw, err := parsed.Raw()
+			if err != nil {
+				t.Fatalf("Raw() returned error: %v", err)
+			}
+
+			reparsed, err := rawToCommand(raw)
+			if err != nil {
+				t.Fatalf("rawToCommand(%q) (round trip) returned error: %v", raw, err)
+			}
+
+			if !reflect.DeepEqual(parsed, reparsed) {
+				t.Errorf("round trip mismatch: %#v != %#v", parsed, reparsed)
+			}
+		})
+	}
+}
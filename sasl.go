@@ -0,0 +1,108 @@
+package girc
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// registerSASLHandlers wires up the handlers that drive the IRCv3
+// SASL handshake: CAP ACK/NAK of the sasl capability, the server's
+// AUTHENTICATE prompts, and the 90x numerics that conclude it.
+func (connection *Connection) registerSASLHandlers() {
+	connection.AddHandler("CAP", func(connection *Connection, command *Command) {
+		if len(command.Args) < 3 || command.Args[1] != "ACK" {
+			return
+		}
+		if !containsCap(command.Args[2], "sasl") {
+			return
+		}
+		select {
+		case connection.capAck <- struct{}{}:
+		default:
+		}
+	})
+
+	connection.AddHandler("AUTHENTICATE", func(connection *Connection, command *Command) {
+		if len(command.Args) > 0 && command.Args[0] == "+" {
+			connection.sendSASLResponse()
+		}
+	})
+
+	connection.AddHandler("903", func(connection *Connection, command *Command) {
+		connection.finishSASLAttempt(nil)
+	})
+
+	for _, numeric := range []string{"904", "905", "906", "907"} {
+		numeric := numeric
+		connection.AddHandler(numeric, func(connection *Connection, command *Command) {
+			connection.finishSASLAttempt(errors.New("SASL authentication failed: " + numeric))
+		})
+	}
+}
+
+func containsCap(caps, name string) bool {
+	for _, c := range strings.Fields(caps) {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (connection *Connection) finishSASLAttempt(err error) {
+	select {
+	case connection.saslDone <- err:
+	default:
+	}
+}
+
+// beginSASL requests the sasl capability and, once the server ACKs
+// it, starts the AUTHENTICATE exchange with the configured mechanism.
+func (connection *Connection) beginSASL() error {
+	if err := connection.Send("CAP", "REQ", "sasl"); err != nil {
+		return err
+	}
+
+	select {
+	case <-connection.capAck:
+	case <-time.After(connection.Timeout):
+		return errors.New("SASL: server did not ACK CAP REQ :sasl")
+	}
+
+	mechanism := connection.SASLMechanism
+	if mechanism == "" {
+		mechanism = "PLAIN"
+	}
+
+	return connection.Send("AUTHENTICATE", mechanism)
+}
+
+// sendSASLResponse answers the server's "AUTHENTICATE +" prompt with
+// the credentials for the configured mechanism.
+func (connection *Connection) sendSASLResponse() {
+	if connection.SASLMechanism == "EXTERNAL" {
+		connection.Send("AUTHENTICATE", "+")
+		return
+	}
+
+	payload := connection.SASLUser + "\x00" + connection.SASLUser + "\x00" + connection.SASLPass
+	connection.Send("AUTHENTICATE", base64.StdEncoding.EncodeToString([]byte(payload)))
+}
+
+// finishSASL waits for the 90x numeric that concludes authentication
+// and, on success, ends capability negotiation so the server can
+// finish registration.
+func (connection *Connection) finishSASL() error {
+	select {
+	case err := <-connection.saslDone:
+		if err != nil {
+			return err
+		}
+	case <-time.After(connection.Timeout):
+		return errors.New("SASL authentication timed out")
+	}
+
+	return connection.Send("CAP", "END")
+}
@@ -0,0 +1,224 @@
+package girc
+
+import (
+	"strings"
+
+	"github.com/gerow/girc/state"
+)
+
+// EnableStateTracking turns on channel/user state tracking for this
+// connection: internal handlers are registered for JOIN/PART/QUIT/
+// KICK/NICK/MODE/TOPIC/353/366/352 and ISUPPORT (005) that keep a
+// state.Tracker in sync as those commands arrive, mirroring
+// fluffle/goirc's state_handlers.go. It's a no-op, returning the
+// existing Tracker, if state tracking is already enabled.
+func (connection *Connection) EnableStateTracking() state.Tracker {
+	if connection.tracker != nil {
+		return connection.tracker
+	}
+
+	connection.tracker = state.New()
+	connection.prefixModes, _ = connection.tracker.Prefixes()
+	connection.registerStateHandlers()
+
+	return connection.tracker
+}
+
+// Tracker returns the Tracker enabled by EnableStateTracking, or nil
+// if state tracking hasn't been enabled.
+func (connection *Connection) Tracker() state.Tracker {
+	return connection.tracker
+}
+
+func (connection *Connection) registerStateHandlers() {
+	connection.AddHandler("001", func(connection *Connection, command *Command) {
+		if len(command.Args) < 1 {
+			return
+		}
+		connection.tracker.HandleWelcome(command.Args[0])
+	})
+
+	connection.AddHandler("JOIN", func(connection *Connection, command *Command) {
+		if len(command.Args) < 1 {
+			return
+		}
+		nick, user, host := splitSource(command.Source)
+		connection.tracker.HandleJoin(command.Args[0], nick, user, host)
+	})
+
+	connection.AddHandler("PART", func(connection *Connection, command *Command) {
+		if len(command.Args) < 1 {
+			return
+		}
+		nick, _, _ := splitSource(command.Source)
+		connection.tracker.HandlePart(command.Args[0], nick)
+	})
+
+	connection.AddHandler("QUIT", func(connection *Connection, command *Command) {
+		nick, _, _ := splitSource(command.Source)
+		if nick != "" {
+			connection.tracker.HandleQuit(nick)
+		}
+	})
+
+	connection.AddHandler("KICK", func(connection *Connection, command *Command) {
+		if len(command.Args) < 2 {
+			return
+		}
+		connection.tracker.HandleKick(command.Args[0], command.Args[1])
+	})
+
+	connection.AddHandler("NICK", func(connection *Connection, command *Command) {
+		if len(command.Args) < 1 {
+			return
+		}
+		oldNick, _, _ := splitSource(command.Source)
+		connection.tracker.HandleNick(oldNick, command.Args[0])
+	})
+
+	connection.AddHandler("TOPIC", func(connection *Connection, command *Command) {
+		if len(command.Args) < 2 {
+			return
+		}
+		connection.tracker.HandleTopic(command.Args[0], command.Args[1])
+	})
+
+	connection.AddHandler("MODE", func(connection *Connection, command *Command) {
+		connection.handleStateMode(command)
+	})
+
+	connection.AddHandler("353", func(connection *Connection, command *Command) {
+		if len(command.Args) < 3 {
+			return
+		}
+		channel := command.Args[len(command.Args)-2]
+		names := strings.Fields(command.Args[len(command.Args)-1])
+		connection.tracker.HandleNamesReply(channel, names)
+	})
+
+	connection.AddHandler("366", func(connection *Connection, command *Command) {
+		if len(command.Args) < 2 {
+			return
+		}
+		connection.tracker.HandleEndOfNames(command.Args[len(command.Args)-2])
+	})
+
+	connection.AddHandler("352", func(connection *Connection, command *Command) {
+		// RPL_WHOREPLY: <client> <channel> <user> <host> <server> <nick> <flags> :<hopcount> <realname>
+		if len(command.Args) < 6 {
+			return
+		}
+		connection.tracker.HandleWho(command.Args[5], command.Args[2], command.Args[3])
+	})
+
+	connection.AddHandler("005", func(connection *Connection, command *Command) {
+		connection.handleISupport(command)
+	})
+}
+
+// handleISupport picks the PREFIX and CHANMODES tokens out of a 005
+// (RPL_ISUPPORT) reply. PREFIX tells us which mode letters are
+// per-nick privileges (and their NAMES-reply symbols); CHANMODES'
+// four comma-separated groups tell MODE parsing which letters take a
+// parameter, and under what circumstances.
+func (connection *Connection) handleISupport(command *Command) {
+	if len(command.Args) < 1 {
+		return
+	}
+	for _, token := range command.Args[1:] {
+		switch {
+		case strings.HasPrefix(token, "PREFIX="):
+			val := strings.TrimPrefix(token, "PREFIX=")
+			if !strings.HasPrefix(val, "(") {
+				continue
+			}
+			end := strings.IndexByte(val, ')')
+			if end == -1 {
+				continue
+			}
+			modes, symbols := val[1:end], val[end+1:]
+			connection.prefixModes = modes
+			connection.tracker.HandleISupportPrefix(modes, symbols)
+		case strings.HasPrefix(token, "CHANMODES="):
+			groups := strings.SplitN(strings.TrimPrefix(token, "CHANMODES="), ",", 4)
+			if len(groups) == 4 {
+				connection.chanModesA, connection.chanModesB, connection.chanModesC = groups[0], groups[1], groups[2]
+			}
+		}
+	}
+}
+
+// handleStateMode tokenizes a channel MODE line - "+o-v nick1 nick2"
+// style - into individual (adding, mode, arg) triples and feeds them
+// to the tracker. User MODE lines (our own umode changes) aren't
+// channel state, so they're ignored here.
+func (connection *Connection) handleStateMode(command *Command) {
+	if len(command.Args) < 2 || !isChannelName(command.Args[0]) {
+		return
+	}
+
+	channel := command.Args[0]
+	modeStr := command.Args[1]
+	params := command.Args[2:]
+	paramIndex := 0
+	adding := true
+
+	for i := 0; i < len(modeStr); i++ {
+		switch modeStr[i] {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		default:
+			mode := modeStr[i]
+			arg := ""
+			if connection.modeTakesArg(mode, adding) && paramIndex < len(params) {
+				arg = params[paramIndex]
+				paramIndex++
+			}
+			connection.tracker.HandleMode(channel, adding, mode, arg)
+		}
+	}
+}
+
+// modeTakesArg reports whether mode consumes a parameter when set
+// (adding) or unset, per ISUPPORT CHANMODES/PREFIX: PREFIX modes and
+// CHANMODES groups A and B always take one; group C only takes one
+// when being added; group D (and anything unrecognized) never does.
+func (connection *Connection) modeTakesArg(mode byte, adding bool) bool {
+	if strings.IndexByte(connection.prefixModes, mode) != -1 {
+		return true
+	}
+	if strings.IndexByte(connection.chanModesA, mode) != -1 {
+		return true
+	}
+	if strings.IndexByte(connection.chanModesB, mode) != -1 {
+		return true
+	}
+	if strings.IndexByte(connection.chanModesC, mode) != -1 {
+		return adding
+	}
+	return false
+}
+
+// isChannelName reports whether target looks like a channel name
+// rather than a nick, based on the commonly used channel prefixes.
+func isChannelName(target string) bool {
+	return target != "" && strings.IndexByte("#&!+", target[0]) != -1
+}
+
+// splitSource splits a message Source ("nick!user@host") into its
+// three parts. Any part the source doesn't include comes back empty.
+func splitSource(source string) (nick, user, host string) {
+	nick = source
+	bang := strings.IndexByte(source, '!')
+	if bang == -1 {
+		return nick, "", ""
+	}
+	nick = source[:bang]
+	rest := source[bang+1:]
+	if at := strings.IndexByte(rest, '@'); at != -1 {
+		return nick, rest[:at], rest[at+1:]
+	}
+	return nick, rest, ""
+}
@@ -0,0 +1,432 @@
+// Package state tracks the channel and user state of an IRC
+// connection - who's on what channel, with what privileges, and
+// basic nick/user/host information - so bot code can ask "who is in
+// #chan right now" without racing girc's reader goroutine.
+//
+// A Tracker knows nothing about the wire protocol itself; it's kept
+// in sync by a driver (girc.Connection.EnableStateTracking) that
+// parses incoming commands and calls the Handle* methods below.
+package state
+
+import (
+	"strings"
+	"sync"
+)
+
+// Nick holds what we know about a single user: their current nick,
+// and (once we've seen it, e.g. via JOIN or WHO) their ident and
+// hostname.
+type Nick struct {
+	Name string
+	User string
+	Host string
+}
+
+// Mask returns the nick in nick!user@host form, as it would appear
+// as a message Source. User/Host may be empty if we haven't seen
+// them yet.
+func (n *Nick) Mask() string {
+	return n.Name + "!" + n.User + "@" + n.Host
+}
+
+// ChanPrivs describes a nick's privilege level on a single channel.
+// The named fields cover the privileges common across networks
+// (owner/admin beyond RFC 1459 op/halfop/voice); any other PREFIX
+// mode a network supports is accepted but not recorded anywhere.
+type ChanPrivs struct {
+	Owner  bool
+	Admin  bool
+	Op     bool
+	HalfOp bool
+	Voice  bool
+}
+
+// Channel holds what we know about a single channel: its topic, the
+// channel modes currently set (value is the mode's argument, or ""
+// for modes that don't take one), and its members.
+type Channel struct {
+	Name    string
+	Topic   string
+	Modes   map[byte]string
+	Members map[string]*ChanPrivs
+}
+
+// Tracker is the read interface bot code uses to query tracked
+// state, plus the event hooks girc.Connection drives it with as
+// messages arrive. All methods are safe to call concurrently.
+type Tracker interface {
+	// Me returns the Nick representing our own connection, or nil
+	// if we haven't seen our own welcome (001) yet.
+	Me() *Nick
+	// GetNick returns the tracked Nick with the given name, or nil
+	// if we've never seen them.
+	GetNick(name string) *Nick
+	// GetChannel returns the tracked Channel with the given name, or
+	// nil if we're not tracking it (typically because we're not on
+	// it).
+	GetChannel(name string) *Channel
+	// IsOn reports whether nick is a member of channel, and if so,
+	// their current privileges on it.
+	IsOn(channel, nick string) (*ChanPrivs, bool)
+	// Prefixes returns the PREFIX mode letters and their matching
+	// NAMES-reply symbols last reported via HandleISupportPrefix, in
+	// matching order (e.g. "ohv", "@%+"). Empty until a 005 with a
+	// PREFIX token has been seen.
+	Prefixes() (modes, symbols string)
+
+	HandleWelcome(nick string)
+	HandleJoin(channel, nick, user, host string)
+	HandlePart(channel, nick string)
+	HandleQuit(nick string)
+	HandleKick(channel, nick string)
+	HandleNick(oldNick, newNick string)
+	HandleMode(channel string, adding bool, mode byte, arg string)
+	HandleTopic(channel, topic string)
+	HandleWho(nick, user, host string)
+	HandleNamesReply(channel string, entries []string)
+	HandleEndOfNames(channel string)
+	HandleISupportPrefix(modes, symbols string)
+}
+
+type tracker struct {
+	lock sync.RWMutex
+
+	me       *Nick
+	nicks    map[string]*Nick
+	channels map[string]*Channel
+
+	// pendingMembers accumulates 353 (NAMES) replies, keyed by
+	// folded channel name, until the matching 366 swaps them in as
+	// the channel's authoritative member list.
+	pendingMembers map[string]map[string]*ChanPrivs
+
+	prefixModes   []byte
+	prefixSymbols []byte
+}
+
+// New returns a Tracker with no nicks or channels and the common
+// default PREFIX (ohv)@%+, used until a real ISUPPORT is seen.
+func New() Tracker {
+	return &tracker{
+		nicks:          make(map[string]*Nick),
+		channels:       make(map[string]*Channel),
+		pendingMembers: make(map[string]map[string]*ChanPrivs),
+		prefixModes:    []byte("ohv"),
+		prefixSymbols:  []byte("@%+"),
+	}
+}
+
+// fold applies the simplified rfc1459 casemapping IRC uses for
+// nick/channel comparisons: ASCII lowercasing plus {}|^ <-> []\~.
+func fold(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		switch c {
+		case '{':
+			b[i] = '['
+		case '}':
+			b[i] = ']'
+		case '|':
+			b[i] = '\\'
+		case '^':
+			b[i] = '~'
+		}
+	}
+	return string(b)
+}
+
+func (t *tracker) Me() *Nick {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.me
+}
+
+func (t *tracker) GetNick(name string) *Nick {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.nicks[fold(name)]
+}
+
+func (t *tracker) GetChannel(name string) *Channel {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.channels[fold(name)]
+}
+
+func (t *tracker) IsOn(channel, nick string) (*ChanPrivs, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	ch, ok := t.channels[fold(channel)]
+	if !ok {
+		return nil, false
+	}
+	privs, ok := ch.Members[fold(nick)]
+	return privs, ok
+}
+
+func (t *tracker) Prefixes() (string, string) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return string(t.prefixModes), string(t.prefixSymbols)
+}
+
+func (t *tracker) getOrCreateNick(name string) *Nick {
+	key := fold(name)
+	if n, ok := t.nicks[key]; ok {
+		return n
+	}
+	n := &Nick{Name: name}
+	t.nicks[key] = n
+	return n
+}
+
+func (t *tracker) getOrCreateChannel(name string) *Channel {
+	key := fold(name)
+	if c, ok := t.channels[key]; ok {
+		return c
+	}
+	c := &Channel{Name: name, Modes: make(map[byte]string), Members: make(map[string]*ChanPrivs)}
+	t.channels[key] = c
+	return c
+}
+
+// onAnyChannel reports whether nick is still a member of any
+// tracked channel, used to decide whether we can forget them
+// entirely after a PART/KICK.
+func (t *tracker) onAnyChannel(nickKey string) bool {
+	for _, ch := range t.channels {
+		if _, ok := ch.Members[nickKey]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tracker) forgetNickIfOrphaned(name string) {
+	key := fold(name)
+	if t.me != nil && fold(t.me.Name) == key {
+		return
+	}
+	if !t.onAnyChannel(key) {
+		delete(t.nicks, key)
+	}
+}
+
+func (t *tracker) HandleWelcome(nick string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.me = t.getOrCreateNick(nick)
+}
+
+func (t *tracker) HandleJoin(channel, nick, user, host string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ch := t.getOrCreateChannel(channel)
+	n := t.getOrCreateNick(nick)
+	if user != "" {
+		n.User = user
+	}
+	if host != "" {
+		n.Host = host
+	}
+
+	key := fold(nick)
+	if _, ok := ch.Members[key]; !ok {
+		ch.Members[key] = &ChanPrivs{}
+	}
+}
+
+func (t *tracker) HandlePart(channel, nick string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := fold(channel)
+	ch, ok := t.channels[key]
+	if !ok {
+		return
+	}
+
+	if t.me != nil && fold(nick) == fold(t.me.Name) {
+		delete(t.channels, key)
+		return
+	}
+
+	delete(ch.Members, fold(nick))
+	t.forgetNickIfOrphaned(nick)
+}
+
+func (t *tracker) HandleKick(channel, nick string) {
+	// A kick removes the same state a PART would.
+	t.HandlePart(channel, nick)
+}
+
+func (t *tracker) HandleQuit(nick string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := fold(nick)
+	for _, ch := range t.channels {
+		delete(ch.Members, key)
+	}
+	if t.me == nil || fold(t.me.Name) != key {
+		delete(t.nicks, key)
+	}
+}
+
+func (t *tracker) HandleNick(oldNick, newNick string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	oldKey, newKey := fold(oldNick), fold(newNick)
+
+	n, ok := t.nicks[oldKey]
+	if !ok {
+		n = &Nick{}
+	}
+	n.Name = newNick
+	delete(t.nicks, oldKey)
+	t.nicks[newKey] = n
+
+	for _, ch := range t.channels {
+		if privs, ok := ch.Members[oldKey]; ok {
+			delete(ch.Members, oldKey)
+			ch.Members[newKey] = privs
+		}
+	}
+}
+
+func setPriv(privs *ChanPrivs, mode byte, adding bool) {
+	switch mode {
+	case 'q':
+		privs.Owner = adding
+	case 'a':
+		privs.Admin = adding
+	case 'o':
+		privs.Op = adding
+	case 'h':
+		privs.HalfOp = adding
+	case 'v':
+		privs.Voice = adding
+	}
+}
+
+func (t *tracker) prefixIndex(mode byte) int {
+	for i, m := range t.prefixModes {
+		if m == mode {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tracker) symbolIndex(symbol byte) int {
+	for i, s := range t.prefixSymbols {
+		if s == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *tracker) HandleMode(channel string, adding bool, mode byte, arg string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ch, ok := t.channels[fold(channel)]
+	if !ok {
+		return
+	}
+
+	if t.prefixIndex(mode) != -1 {
+		key := fold(arg)
+		privs, ok := ch.Members[key]
+		if !ok {
+			privs = &ChanPrivs{}
+			ch.Members[key] = privs
+		}
+		setPriv(privs, mode, adding)
+		return
+	}
+
+	if adding {
+		ch.Modes[mode] = arg
+	} else {
+		delete(ch.Modes, mode)
+	}
+}
+
+func (t *tracker) HandleTopic(channel, topic string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.getOrCreateChannel(channel).Topic = topic
+}
+
+func (t *tracker) HandleWho(nick, user, host string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	n := t.getOrCreateNick(nick)
+	n.User = user
+	n.Host = host
+}
+
+// parseNamesEntry splits a single 353 entry like "@nick" or "+nick"
+// into the bare nick and the privileges its leading prefix symbols
+// imply.
+func (t *tracker) parseNamesEntry(entry string) (string, *ChanPrivs) {
+	privs := &ChanPrivs{}
+	for len(entry) > 0 {
+		idx := t.symbolIndex(entry[0])
+		if idx == -1 {
+			break
+		}
+		setPriv(privs, t.prefixModes[idx], true)
+		entry = entry[1:]
+	}
+	return entry, privs
+}
+
+func (t *tracker) HandleNamesReply(channel string, entries []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := fold(channel)
+	bucket := t.pendingMembers[key]
+	if bucket == nil {
+		bucket = make(map[string]*ChanPrivs)
+		t.pendingMembers[key] = bucket
+	}
+
+	for _, entry := range entries {
+		name, privs := t.parseNamesEntry(entry)
+		if name == "" {
+			continue
+		}
+		t.getOrCreateNick(name)
+		bucket[fold(name)] = privs
+	}
+}
+
+func (t *tracker) HandleEndOfNames(channel string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := fold(channel)
+	bucket, ok := t.pendingMembers[key]
+	if !ok {
+		return
+	}
+	delete(t.pendingMembers, key)
+
+	ch := t.getOrCreateChannel(channel)
+	ch.Members = bucket
+}
+
+func (t *tracker) HandleISupportPrefix(modes, symbols string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prefixModes = []byte(modes)
+	t.prefixSymbols = []byte(symbols)
+}
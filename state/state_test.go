@@ -0,0 +1,172 @@
+package state
+
+import "testing"
+
+func TestJoinPartTracksMembership(t *testing.T) {
+	tr := New()
+	tr.HandleJoin("#chan", "alice", "a", "host.example.com")
+
+	ch := tr.GetChannel("#chan")
+	if ch == nil {
+		t.Fatal("expected #chan to be tracked after JOIN")
+	}
+	if _, ok := tr.IsOn("#chan", "alice"); !ok {
+		t.Fatal("expected alice to be on #chan")
+	}
+
+	n := tr.GetNick("alice")
+	if n == nil || n.User != "a" || n.Host != "host.example.com" {
+		t.Fatalf("expected alice's user/host to be recorded, got %+v", n)
+	}
+
+	tr.HandlePart("#chan", "alice")
+	if _, ok := tr.IsOn("#chan", "alice"); ok {
+		t.Fatal("expected alice to be off #chan after PART")
+	}
+	if tr.GetNick("alice") != nil {
+		t.Fatal("expected alice to be forgotten once on no channels")
+	}
+}
+
+func TestSelfPartForgetsChannel(t *testing.T) {
+	tr := New()
+	tr.HandleWelcome("me")
+	tr.HandleJoin("#chan", "me", "m", "host")
+	tr.HandlePart("#chan", "me")
+
+	if tr.GetChannel("#chan") != nil {
+		t.Fatal("expected #chan to be forgotten once we part it ourselves")
+	}
+	if tr.Me() == nil {
+		t.Fatal("expected our own Nick to survive parting a channel")
+	}
+}
+
+func TestKickActsLikePart(t *testing.T) {
+	tr := New()
+	tr.HandleJoin("#chan", "alice", "a", "h")
+	tr.HandleKick("#chan", "alice")
+
+	if _, ok := tr.IsOn("#chan", "alice"); ok {
+		t.Fatal("expected alice to be off #chan after KICK")
+	}
+}
+
+func TestQuitRemovesFromAllChannels(t *testing.T) {
+	tr := New()
+	tr.HandleJoin("#a", "alice", "a", "h")
+	tr.HandleJoin("#b", "alice", "a", "h")
+	tr.HandleQuit("alice")
+
+	if _, ok := tr.IsOn("#a", "alice"); ok {
+		t.Fatal("expected alice to be gone from #a")
+	}
+	if _, ok := tr.IsOn("#b", "alice"); ok {
+		t.Fatal("expected alice to be gone from #b")
+	}
+}
+
+func TestNickChangeMovesMembership(t *testing.T) {
+	tr := New()
+	tr.HandleJoin("#chan", "alice", "a", "h")
+	tr.HandleNick("alice", "alicia")
+
+	if _, ok := tr.IsOn("#chan", "alice"); ok {
+		t.Fatal("expected old nick to no longer be on #chan")
+	}
+	if _, ok := tr.IsOn("#chan", "alicia"); !ok {
+		t.Fatal("expected new nick to be on #chan")
+	}
+	if tr.GetNick("alice") != nil {
+		t.Fatal("expected old nick to no longer be tracked")
+	}
+}
+
+func TestCasefoldingMatchesChannelsAndNicks(t *testing.T) {
+	tr := New()
+	tr.HandleJoin("#Chan", "Alice", "a", "h")
+
+	if tr.GetChannel("#chan") == nil {
+		t.Fatal("expected #chan and #Chan to fold to the same channel")
+	}
+	if _, ok := tr.IsOn("#CHAN", "alice"); !ok {
+		t.Fatal("expected nick/channel lookups to casefold")
+	}
+}
+
+func TestNamesReplyBuffersUntilEndOfNames(t *testing.T) {
+	tr := New()
+	tr.HandleISupportPrefix("ov", "@+")
+
+	tr.HandleNamesReply("#chan", []string{"@alice", "+bob", "carol"})
+	if tr.GetChannel("#chan") != nil {
+		t.Fatal("expected no members to land until 366 (RPL_ENDOFNAMES)")
+	}
+
+	tr.HandleEndOfNames("#chan")
+	ch := tr.GetChannel("#chan")
+	if ch == nil {
+		t.Fatal("expected #chan to exist after 366")
+	}
+
+	privs, ok := tr.IsOn("#chan", "alice")
+	if !ok || !privs.Op {
+		t.Fatalf("expected alice to be op, got %+v", privs)
+	}
+	privs, ok = tr.IsOn("#chan", "bob")
+	if !ok || !privs.Voice {
+		t.Fatalf("expected bob to be voiced, got %+v", privs)
+	}
+	if _, ok := tr.IsOn("#chan", "carol"); !ok {
+		t.Fatal("expected carol to be a plain member")
+	}
+}
+
+func TestHandleModeTracksPrivsAndChannelModes(t *testing.T) {
+	tr := New()
+	tr.HandleISupportPrefix("ov", "@+")
+	tr.HandleJoin("#chan", "alice", "a", "h")
+
+	tr.HandleMode("#chan", true, 'o', "alice")
+	privs, ok := tr.IsOn("#chan", "alice")
+	if !ok || !privs.Op {
+		t.Fatalf("expected alice to be opped, got %+v", privs)
+	}
+
+	tr.HandleMode("#chan", false, 'o', "alice")
+	privs, _ = tr.IsOn("#chan", "alice")
+	if privs.Op {
+		t.Fatal("expected alice to be de-opped")
+	}
+
+	tr.HandleMode("#chan", true, 'k', "secret")
+	if tr.GetChannel("#chan").Modes['k'] != "secret" {
+		t.Fatal("expected channel mode k to be recorded with its argument")
+	}
+	tr.HandleMode("#chan", false, 'k', "")
+	if _, ok := tr.GetChannel("#chan").Modes['k']; ok {
+		t.Fatal("expected channel mode k to be cleared")
+	}
+}
+
+func TestHandleTopic(t *testing.T) {
+	tr := New()
+	tr.HandleTopic("#chan", "welcome")
+	if tr.GetChannel("#chan").Topic != "welcome" {
+		t.Fatal("expected topic to be recorded")
+	}
+}
+
+func TestHandleISupportPrefixOverridesDefault(t *testing.T) {
+	tr := New()
+	modes, symbols := tr.Prefixes()
+	if modes != "ohv" || symbols != "@%+" {
+		t.Fatalf("expected default prefixes ohv/@%%+, got %q/%q", modes, symbols)
+	}
+
+	tr.HandleISupportPrefix("qaohv", "~&@%+")
+	modes, symbols = tr.Prefixes()
+	if modes != "qaohv" || symbols != "~&@%+" {
+		t.Fatalf("expected overridden prefixes qaohv/~&@%%+, got %q/%q", modes, symbols)
+	}
+}
@@ -0,0 +1,15 @@
+package girc
+
+import "testing"
+
+func TestHandleISupportIgnoresShortArgs(t *testing.T) {
+	connection := New("irc.example.com:6667", "nick")
+	connection.EnableStateTracking()
+
+	command, err := rawToCommand(":server 005\r\n")
+	if err != nil {
+		t.Fatalf("rawToCommand: %v", err)
+	}
+
+	connection.dispatch(command)
+}
@@ -0,0 +1,133 @@
+package girc
+
+import (
+	"fmt"
+	"time"
+)
+
+// floodLineCost is the "M" in "allow a burst of N lines, then
+// throttle to one line per M milliseconds" - the badness added to
+// the bucket for every line sent.
+const floodLineCost = 500 * time.Millisecond
+
+// defaultFloodBurst is the "N" above: how many lines may be sent
+// back-to-back, via BadnessThreshold, before throttling kicks in.
+const defaultFloodBurst = 5
+
+// writeLoop owns connection.conn's write side: every outgoing line
+// passes through connection.out so SendCommand never blocks on the
+// socket directly and never races the reader's use of conn. Lines are
+// rate limited by throttle() unless Flood is set. On a disconnect,
+// DrainOnClose decides whether buffered lines are still flushed out
+// or simply abandoned.
+func (connection *Connection) writeLoop() {
+	defer connection.wg.Done()
+
+	for {
+		select {
+		case <-connection.die:
+			if connection.DrainOnClose {
+				connection.drainOut()
+			} else {
+				connection.discardOut()
+			}
+			return
+		case line, ok := <-connection.out:
+			if !ok {
+				return
+			}
+			connection.throttle()
+			connection.writeConn(line)
+		}
+	}
+}
+
+// writeConn writes raw directly to the socket, serialized against
+// every other writer (writeLoop's own sends, drainOut, and Quit) by
+// connWriteLock so two writes can never interleave mid-line on the
+// wire.
+func (connection *Connection) writeConn(raw string) {
+	connection.connWriteLock.Lock()
+	defer connection.connWriteLock.Unlock()
+	fmt.Fprint(connection.conn, raw)
+}
+
+// drainOut flushes whatever is currently buffered in connection.out
+// straight to the socket, without throttling, and returns as soon as
+// the buffer is empty.
+func (connection *Connection) drainOut() {
+	for {
+		select {
+		case line := <-connection.out:
+			connection.writeConn(line)
+		default:
+			return
+		}
+	}
+}
+
+// discardOut reads and drops whatever is currently buffered in
+// connection.out, so it can't sit ahead of the next connection
+// cycle's NICK/USER/CAP traffic on a Loop() reconnect.
+func (connection *Connection) discardOut() {
+	for {
+		select {
+		case <-connection.out:
+		default:
+			return
+		}
+	}
+}
+
+// throttle blocks the caller (the writer goroutine) for as long as
+// it takes for accumulated "badness" to fall back under
+// BadnessThreshold. Every line sent adds floodLineCost of badness;
+// badness otherwise leaks away in real time, so a burst of lines can
+// go out immediately before throttling kicks in.
+func (connection *Connection) throttle() {
+	if connection.Flood {
+		return
+	}
+
+	connection.floodLock.Lock()
+	now := time.Now()
+	if !connection.lastSend.IsZero() {
+		elapsed := now.Sub(connection.lastSend)
+		if elapsed >= connection.badness {
+			connection.badness = 0
+		} else {
+			connection.badness -= elapsed
+		}
+	}
+	wait := connection.badness - connection.BadnessThreshold
+	connection.badness += floodLineCost
+	connection.lastSend = now
+	connection.floodLock.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// SendRaw enqueues an already-formatted line (including its
+// terminating "\r\n") to be written to the server, subject to the
+// same flood protection and drain-on-close rules as SendCommand.
+func (connection *Connection) SendRaw(raw string) error {
+	connection.out <- raw
+	return nil
+}
+
+// Quit sends a QUIT with the given reason, bypassing the flood queue
+// so it can't be left stranded behind throttled traffic, then closes
+// the connection. The write is serialized through the same
+// connWriteLock as writeLoop/drainOut, so it can't interleave with
+// them mid-line on the wire.
+func (connection *Connection) Quit(reason string) error {
+	raw, err := (&Command{Type: "QUIT", Args: []string{reason}}).Raw()
+	if err != nil {
+		return err
+	}
+	connection.writeConn(raw)
+
+	return connection.Close()
+}
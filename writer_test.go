@@ -0,0 +1,40 @@
+package girc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleFloodDisabled(t *testing.T) {
+	connection := New("irc.example.com:6667", "nick")
+	connection.Flood = true
+	connection.BadnessThreshold = time.Millisecond
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		connection.throttle()
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("throttle slept for %v with Flood enabled", elapsed)
+	}
+}
+
+func TestThrottleBurstThenDelay(t *testing.T) {
+	connection := New("irc.example.com:6667", "nick")
+	connection.BadnessThreshold = 2 * floodLineCost
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		connection.throttle()
+	}
+	burstElapsed := time.Since(start)
+	if burstElapsed > floodLineCost/2 {
+		t.Fatalf("expected the first few sends within BadnessThreshold to be instant, took %v", burstElapsed)
+	}
+
+	connection.throttle()
+	totalElapsed := time.Since(start)
+	if totalElapsed < floodLineCost/2 {
+		t.Fatalf("expected a send beyond BadnessThreshold to be delayed, only took %v", totalElapsed)
+	}
+}